@@ -6,47 +6,93 @@
 // dashboard so they can be accessed and searched from the local file
 // system.
 //
-// It organizes these logs into two directories created in the current
-// working directory. The log/ directory contains all log files named
-// the same way they are named by the dashboard (which happens to be
-// the SHA-1 of their contents). The rev/ directory contains symlinks
-// back to these logs named
+// It organizes these logs into two directories created in its output
+// directory, which defaults to $XDG_CACHE_HOME/dashscrape (or
+// ~/.cache/dashscrape) and can be changed with the -dir flag. The
+// log/ directory contains all log files named the same way they are
+// named by the dashboard (which happens to be the SHA-1 of their
+// contents). The rev/ directory contains symlinks back to these logs
+// named
 //
 //    rev/<ISO 8601 commit date>-<git revision>/<builder>
 //
 // Dashscrape will reuse existing log files and revision symlinks, so
 // it only has to download logs that are new since the last time it
-// was run.
+// was run. By default it stops paging the dashboard once it reaches
+// the most recent revision it fetched on a previous run, recorded in
+// a small state file in the output directory. Pass -all to ignore
+// this cursor and re-walk the most recent -n commits, or -since to
+// fetch back to a fixed point in time instead.
 //
-// Dashscrape needs access to an up-to-date clone of the Go repository
-// to resolve commit hashes to commit dates. This defaults to ~/go,
-// but can be changed with the -C command line flag.
+// Dashscrape resolves commit hashes to commit dates using the
+// maintner corpus (a mutation log of go.googlesource.com repositories),
+// so it no longer needs a local clone of the Go repository.
+//
+// The "dashscrape search" subcommand queries an index that's kept up
+// to date as logs are fetched, so builders can be searched by content
+// without grepping the log/ directory directly. For example:
+//
+//	dashscrape search 'builder:linux-amd64 since:2024-01-01 /panic: runtime/'
+//
+// Passing -perf also mirrors benchmark artifacts the dashboard
+// recorded for each commit/builder pair into a bench/ tree, using the
+// same content-addressed-storage-plus-symlink scheme as log/ and
+// rev/, and appends the parsed benchmark records to bench/all.txt so
+// benchstat can be run directly against the mirrored output.
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
-	"strings"
+	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/golang/build/types"
+	"github.com/aclements/go-misc/dashscrape/index"
+	"golang.org/x/build/maintner"
+	"golang.org/x/build/maintner/godata"
+	"golang.org/x/build/types"
+	"golang.org/x/perf/benchfmt"
 )
 
 var (
-	goRepo   = flag.String("C", os.ExpandEnv("$HOME/go"), "Go repository `path`")
 	nCommits = flag.Int("n", 300, "fetch logs for most recent `count` commits")
 	par      = flag.Int("j", 5, "download `num` files concurrently")
+	outDir   = flag.String("dir", defaultOutDir(), "store logs and state in `directory`")
+	allRevs  = flag.Bool("all", false, "ignore the saved cursor and fetch the most recent -n commits")
+	since    = flag.Duration("since", 0, "ignore the saved cursor and fetch commits from the last `duration`")
+	perfMode = flag.Bool("perf", false, "also mirror benchmark artifacts into bench/")
 )
 
+// stateFile is the name of the file, within outDir, that records the
+// incremental fetch cursor.
+const stateFile = "state.json"
+
+// defaultOutDir returns the default output directory,
+// $XDG_CACHE_HOME/dashscrape, falling back to ~/.cache/dashscrape.
+func defaultOutDir() string {
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" {
+		cache = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(cache, "dashscrape")
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		runSearch(os.Args[2:])
+		return
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr,
 			"Download recent build failure logs to the current directory.\n\n"+
@@ -57,9 +103,50 @@ func main() {
 	}
 	flag.Parse()
 
+	if err := os.MkdirAll(*outDir, 0777); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(*outDir); err != nil {
+		log.Fatal(err)
+	}
+
+	// Load the maintner corpus. This gives us commit metadata
+	// in-process instead of shelling out to git.
+	var err error
+	corpus, err = godata.Get(context.Background())
+	if err != nil {
+		log.Fatal("loading maintner corpus: ", err)
+	}
+
 	// Create directory structure
 	ensureDir("log")
 	ensureDir("rev")
+	if *perfMode {
+		ensureDir("bench-data")
+		ensureDir("bench")
+		if err := openBenchAll(); err != nil {
+			log.Fatal("opening bench/all.txt: ", err)
+		}
+	}
+
+	searchIndex, err = index.Open("index")
+	if err != nil {
+		log.Fatal("opening search index: ", err)
+	}
+
+	// Figure out where to stop paging the dashboard. By default
+	// we stop at the cursor left by the previous run; -all and
+	// -since both override that.
+	st := loadState(stateFile)
+	stopAtRev := st.Rev
+	var sinceTime time.Time
+	if *allRevs {
+		stopAtRev = ""
+	}
+	if *since > 0 {
+		stopAtRev = ""
+		sinceTime = time.Now().Add(-*since)
+	}
 
 	// Set up fetchers
 	fetchTokens = make(chan struct{}, *par)
@@ -70,6 +157,8 @@ func main() {
 
 	// Fetch dashboard pages
 	haveCommits := 0
+	newestRev := ""
+pages:
 	for page := 0; haveCommits < *nCommits; page++ {
 		// TODO: What if we go past the last page?
 		url := fmt.Sprintf("http://build.golang.org/?mode=json&page=%d", page)
@@ -88,6 +177,19 @@ func main() {
 		resp.Body.Close()
 
 		for _, rev := range status.Revisions {
+			if newestRev == "" {
+				newestRev = rev.Revision
+			}
+			if stopAtRev != "" && rev.Revision == stopAtRev {
+				// We've caught up to the previous run.
+				break pages
+			}
+			if !sinceTime.IsZero() {
+				if date, err := revDate(rev.Revision); err == nil && date.Before(sinceTime) {
+					break pages
+				}
+			}
+
 			haveCommits++
 			if haveCommits > *nCommits {
 				break
@@ -103,7 +205,7 @@ func main() {
 				wg.Add(1)
 				go func(rev, builder, logURL string) {
 					defer wg.Done()
-					logPath, err := fetchLog(logURL)
+					logPath, err := fetchLog(logURL, rev, builder)
 					if err != nil {
 						log.Fatal("fetching log: ", err)
 					}
@@ -112,14 +214,65 @@ func main() {
 					}
 				}(rev.Revision, status.Builders[i], res)
 			}
+
+			if *perfMode {
+				// Benchmark results are reported
+				// independently of pass/fail, so check
+				// every builder regardless of its
+				// rev.Results entry.
+				for _, builder := range status.Builders {
+					wg.Add(1)
+					go func(rev, builder string) {
+						defer wg.Done()
+						if err := fetchPerf(rev, builder); err != nil {
+							log.Fatal("fetching perf results: ", err)
+						}
+					}(rev.Revision, builder)
+				}
+			}
 		}
 	}
 
 	wg.Wait()
 
-	// TODO: Record latest commit so we can fetch up to it and
-	// stop. Or maybe it's so cheap to get the indexes that it
-	// just doesn't matter if we download, say, 10 index pages.
+	if newestRev != "" {
+		saveState(stateFile, state{Rev: newestRev})
+	}
+}
+
+// state is the incremental fetch cursor persisted to stateFile.
+type state struct {
+	// Rev is the most recent revision seen on the dashboard as
+	// of the last successful run. Paging stops once we see it
+	// again, unless -all or -since is given.
+	Rev string `json:"rev"`
+}
+
+// loadState reads the persisted cursor from path. If path doesn't
+// exist or is corrupt, it returns the zero state so dashscrape falls
+// back to fetching the most recent -n commits.
+func loadState(path string) state {
+	var st state
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return st
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("ignoring corrupt state file %s: %v", path, err)
+		return state{}
+	}
+	return st
+}
+
+// saveState persists the incremental fetch cursor to path.
+func saveState(path string, st state) {
+	data, err := json.Marshal(st)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		log.Fatal("saving state: ", err)
+	}
 }
 
 // ensureDir creates directory name if it does not exist.
@@ -130,6 +283,15 @@ func ensureDir(name string) {
 	}
 }
 
+// ensureDirAll creates directory name, and any missing parents, if it
+// does not exist.
+func ensureDirAll(name string) {
+	err := os.MkdirAll(name, 0777)
+	if err != nil && !os.IsExist(err) {
+		log.Fatal(err)
+	}
+}
+
 type pendingFetch struct {
 	err   error
 	wchan chan struct{}
@@ -140,19 +302,28 @@ var fetches = map[string]*pendingFetch{}
 
 var fetchTokens chan struct{}
 
-// fetchLog downloads the build log from logURL and returns the file
-// path it was written to. If the destination file already exists,
-// this returns immediately.
+// searchIndex is the index of fetched logs used by "dashscrape
+// search". It's opened once at startup and updated as new logs are
+// fetched.
+var searchIndex *index.Index
+
+// fetchLog downloads the build log from logURL, for revision and
+// builder, and returns the file path it was written to. If the
+// destination file already exists, this returns immediately after
+// making sure it's recorded in the search index.
 //
 // This is safe to call concurrently. If multiple fetchLogs are called
 // with the same log URL, they will all block until the log is saved
 // to disk.
-func fetchLog(logURL string) (string, error) {
+func fetchLog(logURL, revision, builder string) (string, error) {
 	logPath := path.Join("log", path.Base(logURL))
 
-	// Do we already have it?
+	// Do we already have it? If so, it may still be missing from
+	// the search index -- e.g. the index was rebuilt from scratch
+	// while log/ was kept -- so index it unconditionally; Index.Add
+	// is a no-op for a log that's already indexed.
 	if _, err := os.Stat(logPath); err == nil {
-		return logPath, nil
+		return logPath, indexLog(logPath, revision, builder)
 	} else if !os.IsNotExist(err) {
 		return "", err
 	}
@@ -169,21 +340,63 @@ func fetchLog(logURL string) (string, error) {
 	fetches[logURL] = p
 	fetchesLock.Unlock()
 
-	p.err = fetchLogNoSync(logURL, logPath)
+	p.err = fetchLogNoSync(logURL, logPath, revision, builder)
 	close(p.wchan)
 	return logPath, p.err
 }
 
-func fetchLogNoSync(logURL, logPath string) error {
+func fetchLogNoSync(logURL, logPath, revision, builder string) error {
+	if err := downloadFile(logURL, logPath); err != nil {
+		return err
+	}
+	return indexLog(logPath, revision, builder)
+}
+
+// indexLog records logPath in the search index, if one is open. It's
+// a no-op if logPath is already indexed, so it's safe to call for a
+// log regardless of whether it was just downloaded or already on
+// disk.
+func indexLog(logPath, revision, builder string) error {
+	if searchIndex == nil {
+		return nil
+	}
+
+	date, err := revDate(revision)
+	if err != nil {
+		return fmt.Errorf("indexing %s: %v", logPath, err)
+	}
+	// Store an absolute path so the index is still valid when read
+	// back by "dashscrape search", which runs from whatever
+	// directory the user happens to be in, not the -dir directory
+	// dashscrape itself chdirs into while fetching.
+	absLogPath, err := filepath.Abs(logPath)
+	if err != nil {
+		return fmt.Errorf("indexing %s: %v", logPath, err)
+	}
+	if err := searchIndex.Add(index.Entry{
+		Revision: revision,
+		Builder:  builder,
+		Date:     date,
+		LogPath:  absLogPath,
+	}); err != nil {
+		return fmt.Errorf("indexing %s: %v", logPath, err)
+	}
+	return nil
+}
+
+// downloadFile fetches url and atomically writes its body to
+// destPath, using a .tmp suffix and rename so a crash mid-download
+// never leaves a truncated file at destPath.
+func downloadFile(url, destPath string) error {
 	<-fetchTokens
-	fmt.Println("fetching", logURL)
-	resp, err := http.Get(logURL)
+	fmt.Println("fetching", url)
+	resp, err := http.Get(url)
 	fetchTokens <- struct{}{}
 	if err != nil {
 		return err
 	}
 
-	if f, err := os.Create(logPath + ".tmp"); err != nil {
+	if f, err := os.Create(destPath + ".tmp"); err != nil {
 		return err
 	} else {
 		_, err := io.Copy(f, resp.Body)
@@ -193,12 +406,12 @@ func fetchLogNoSync(logURL, logPath string) error {
 		resp.Body.Close()
 		f.Close()
 		if err != nil {
-			os.Remove(logPath + ".tmp")
+			os.Remove(destPath + ".tmp")
 			return err
 		}
 	}
-	if err := os.Rename(logPath+".tmp", logPath); err != nil {
-		os.Remove(logPath + ".tmp")
+	if err := os.Rename(destPath+".tmp", destPath); err != nil {
+		os.Remove(destPath + ".tmp")
 		return err
 	}
 
@@ -208,16 +421,20 @@ func fetchLogNoSync(logURL, logPath string) error {
 // linkLog creates a symlink for finding logPath based on its git
 // revision and builder.
 func linkLog(revision, builder, logPath string) error {
-	// Get revision date info
 	date, err := revDate(revision)
 	if err != nil {
 		return err
 	}
+	return linkLogAt(revision, builder, logPath, date)
+}
 
-	// Create symlink
+// linkLogAt is linkLog with the revision's date already resolved, so
+// the directory/symlink layout it creates can be tested without
+// resolving a real revision's date.
+func linkLogAt(revision, builder, logPath string, date time.Time) error {
 	out := path.Join("rev", date.Format("2006-01-02T15:04:05")+"-"+revision[:7])
 	ensureDir(out)
-	err = os.Symlink("../../"+logPath, path.Join(out, builder))
+	err := os.Symlink("../../"+logPath, path.Join(out, builder))
 	if err != nil && !os.IsExist(err) {
 		return err
 	}
@@ -225,17 +442,291 @@ func linkLog(revision, builder, logPath string) error {
 	return nil
 }
 
+// PerfResult is the benchmark artifact listing the dashboard reports
+// for a single (revision, builder) pair. This mirrors the
+// PerfResult/PerfArtifact scheme used by the old dashboard/builder.
+type PerfResult struct {
+	Artifacts []PerfArtifact
+}
+
+// PerfArtifact is a single benchmark artifact belonging to a
+// PerfResult. Type is "benchmark" for the raw `go test -bench` text
+// output, or "json" for the dashboard's parsed result.
+type PerfArtifact struct {
+	Type string
+	URL  string
+}
+
+// fetchPerfResult fetches the list of benchmark artifacts the
+// dashboard recorded for revision on builder. It returns a nil
+// PerfResult if builder didn't report any benchmarks for revision.
+func fetchPerfResult(revision, builder string) (*PerfResult, error) {
+	url := fmt.Sprintf("http://build.golang.org/perf?mode=json&commit=%s&builder=%s", revision, builder)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	var result PerfResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unmarshalling perf result: %v", err)
+	}
+	if len(result.Artifacts) == 0 {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// fetchArtifact downloads the benchmark artifact at artifactURL into
+// bench-data/, named by content hash exactly like fetchLog names log
+// files, and returns the path it was written to and whether it was
+// newly downloaded by this call (as opposed to already present from
+// a previous run).
+func fetchArtifact(artifactURL string) (artifactPath string, fresh bool, err error) {
+	artifactPath = path.Join("bench-data", path.Base(artifactURL))
+
+	if _, err := os.Stat(artifactPath); err == nil {
+		return artifactPath, false, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	fetchesLock.Lock()
+	if p, ok := fetches[artifactURL]; ok {
+		fetchesLock.Unlock()
+		<-p.wchan
+		// The fetcher that created p is responsible for any
+		// new-artifact side effects (like appendBench); we
+		// just waited for it, so this isn't fresh to us.
+		return artifactPath, false, p.err
+	}
+
+	p := &pendingFetch{wchan: make(chan struct{})}
+	fetches[artifactURL] = p
+	fetchesLock.Unlock()
+
+	p.err = downloadFile(artifactURL, artifactPath)
+	close(p.wchan)
+	return artifactPath, p.err == nil, p.err
+}
+
+// fetchPerf downloads and links all benchmark artifacts the dashboard
+// has for revision on builder, if any.
+func fetchPerf(revision, builder string) error {
+	result, err := fetchPerfResult(revision, builder)
+	if err != nil {
+		return fmt.Errorf("fetching perf result for %s %s: %v", revision, builder, err)
+	}
+	if result == nil {
+		return nil
+	}
+
+	date, err := revDate(revision)
+	if err != nil {
+		return err
+	}
+
+	artifactPaths := map[string]string{}
+	for _, a := range result.Artifacts {
+		artifactPath, fresh, err := fetchArtifact(a.URL)
+		if err != nil {
+			return fmt.Errorf("fetching artifact %s: %v", a.URL, err)
+		}
+		artifactPaths[a.Type] = artifactPath
+
+		// Only append a benchmark artifact's records the one
+		// time it's newly fetched -- otherwise a re-run (e.g.
+		// with -all) would duplicate every record it already
+		// appended into bench/all.txt.
+		if a.Type == "benchmark" && fresh {
+			if err := appendBench(artifactPath, revision, builder, date); err != nil {
+				return fmt.Errorf("appending %s to bench/all.txt: %v", artifactPath, err)
+			}
+		}
+	}
+
+	return linkBench(revision, builder, date, artifactPaths)
+}
+
+// linkBench creates symlinks for finding a builder's downloaded
+// benchmark artifacts by git revision, mirroring the log/rev scheme
+// used for build logs.
+func linkBench(revision, builder string, date time.Time, artifactPaths map[string]string) error {
+	out := path.Join("bench", date.Format("2006-01-02T15:04:05")+"-"+revision[:7], builder)
+	// Unlike linkLog's rev/<date>-<rev> (one new level under the
+	// already-existing rev/), out is two new levels deep under
+	// bench/, so it needs MkdirAll, not ensureDir's single-level
+	// Mkdir.
+	ensureDirAll(out)
+	for typ, artifactPath := range artifactPaths {
+		err := os.Symlink("../../../"+artifactPath, path.Join(out, typ))
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchAllPath is the merged benchmark file that accumulates every
+// mirrored "benchmark"-type artifact, so benchstat can be run
+// directly against it.
+const benchAllPath = "bench/all.txt"
+
+// benchAllMu serializes writes to benchAllPath. appendBench is called
+// from concurrent fetchPerf goroutines, and benchfmt.Writer is not
+// safe for concurrent use, so every write goes through this mutex and
+// the single shared writer it guards.
+var (
+	benchAllMu     sync.Mutex
+	benchAllFile   *os.File
+	benchAllWriter *benchfmt.Writer
+)
+
+// openBenchAll opens (creating if necessary) the shared benchAllPath
+// writer used by appendBench. It must be called once, before any
+// fetchPerf goroutines start, while *perfMode is set.
+func openBenchAll() error {
+	f, err := os.OpenFile(benchAllPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	benchAllFile = f
+	benchAllWriter = benchfmt.NewWriter(f)
+	return nil
+}
+
+// appendBench parses the benchmark text artifact at artifactPath and
+// appends its records to benchAllPath, tagging each with the
+// revision, builder, and commit date so benchstat can group and
+// compare across runs. It's safe to call concurrently.
+func appendBench(artifactPath, revision, builder string, date time.Time) error {
+	in, err := os.Open(artifactPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	benchAllMu.Lock()
+	defer benchAllMu.Unlock()
+
+	r := benchfmt.NewReader(in, artifactPath)
+	for r.Scan() {
+		rec := r.Result()
+		result, ok := rec.(*benchfmt.Result)
+		if !ok {
+			// Skip *benchfmt.SyntaxError and
+			// *benchfmt.UnitMetadata records; we only want
+			// actual benchmark results.
+			continue
+		}
+		result.SetConfig("revision", revision)
+		result.SetConfig("builder", builder)
+		result.SetConfig("commit-date", date.Format("2006-01-02"))
+		if err := benchAllWriter.Write(result); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+// corpus is the maintner corpus of go.googlesource.com repositories,
+// loaded once at startup. It lets revDate resolve git revisions to
+// commit metadata without an up-to-date local clone of the Go
+// repository.
+var corpus *maintner.Corpus
+
 // revDate returns the commit date of a git revision.
 func revDate(revision string) (time.Time, error) {
-	args := []string{"-C", *goRepo, "log", "-1", "--format=%cD", revision}
-	out, err := exec.Command("git", args...).CombinedOutput()
-	outs := string(out)
+	if rev := corpus.GitCommit(revision); rev != nil {
+		return rev.CommitTime, nil
+	}
+
+	// The commit may be too recent to have made it into the
+	// maintner corpus yet. Fall back to asking Gerrit directly.
+	return revDateGerrit(revision)
+}
+
+// gerritXSSIPrefix is prepended to Gerrit's JSON responses as an
+// XSSI guard and must be stripped before parsing.
+var gerritXSSIPrefix = []byte(")]}'\n")
+
+// revDateGerrit queries the Go Gerrit instance over HTTPS for the
+// commit date of revision. This is slower than consulting the
+// maintner corpus, but it sees commits before maintner has caught up
+// with them.
+func revDateGerrit(revision string) (time.Time, error) {
+	url := "https://go.googlesource.com/go/+/" + revision + "?format=JSON"
+	resp, err := http.Get(url)
 	if err != nil {
-		if strings.Contains(outs, "bad object") {
-			err = fmt.Errorf("unknown commit; try sync'ing your repository")
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return time.Time{}, fmt.Errorf("unknown commit; try again later")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, err
+	}
+	body = bytes.TrimPrefix(body, gerritXSSIPrefix)
+
+	var info struct {
+		Committer struct {
+			Time string `json:"time"`
+		} `json:"committer"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return time.Time{}, fmt.Errorf("unmarshalling gerrit response for %s: %v", revision, err)
+	}
+	return time.Parse("Mon Jan 02 15:04:05 2006 -0700", info.Committer.Time)
+}
+
+// runSearch implements the "dashscrape search" subcommand.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	dir := fs.String("dir", defaultOutDir(), "search the index in `directory`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr,
+			"Usage: dashscrape search [flags] <query>\n\n"+
+				"Query syntax: [builder:<name>] [since:<YYYY-MM-DD>] [/regexp/]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	q, err := index.ParseQuery(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idx, err := index.Open(path.Join(*dir, "index"))
+	if err != nil {
+		log.Fatal("opening search index: ", err)
+	}
+	matches, err := idx.Search(q)
+	if err != nil {
+		log.Fatal("searching: ", err)
+	}
+
+	for _, m := range matches {
+		rev := m.Entry.Revision
+		if len(rev) > 7 {
+			rev = rev[:7]
 		}
-		return time.Time{}, fmt.Errorf("git %v: %v", strings.Join(args, " "), err)
+		fmt.Printf("%s\t%s\t%s\t%s\n", m.Entry.LogPath, rev, m.Entry.Builder, m.Snippet)
 	}
-	outs = strings.TrimRight(outs, "\n")
-	return time.Parse("Mon, 2 Jan 2006 15:04:05 -0700", outs)
 }
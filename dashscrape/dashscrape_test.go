@@ -0,0 +1,122 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, stateFile)
+
+	// A missing file falls back to the zero state instead of
+	// erroring, so a first run has nothing to ignore.
+	if st := loadState(p); st.Rev != "" {
+		t.Fatalf("loadState of a missing file = %+v, want zero state", st)
+	}
+
+	saveState(p, state{Rev: "abc123"})
+	if st := loadState(p); st.Rev != "abc123" {
+		t.Fatalf("loadState after saveState = %+v, want Rev abc123", st)
+	}
+}
+
+func TestLoadStateCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, stateFile)
+	if err := ioutil.WriteFile(p, []byte("not json"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if st := loadState(p); st.Rev != "" {
+		t.Fatalf("loadState of a corrupt file = %+v, want zero state", st)
+	}
+}
+
+// withTempOutDir chdirs into a fresh temporary directory for the
+// duration of the test, restoring the previous working directory
+// afterward.
+func withTempOutDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLinkLogAt(t *testing.T) {
+	withTempOutDir(t)
+	ensureDir("log")
+	ensureDir("rev")
+
+	logPath := path.Join("log", "deadbeef")
+	if err := ioutil.WriteFile(logPath, []byte("log content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := linkLogAt("abcdefg1234", "linux-amd64", logPath, date); err != nil {
+		t.Fatalf("linkLogAt: %v", err)
+	}
+
+	link := path.Join("rev", "2024-01-02T03:04:05-abcdefg", "linux-amd64")
+	got, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("reading through symlink %s: %v", link, err)
+	}
+	if string(got) != "log content" {
+		t.Errorf("reading through symlink %s = %q, want %q", link, got, "log content")
+	}
+}
+
+func TestLinkBench(t *testing.T) {
+	withTempOutDir(t)
+	ensureDir("bench-data")
+	ensureDir("bench")
+
+	artifactPath := path.Join("bench-data", "cafef00d")
+	if err := ioutil.WriteFile(artifactPath, []byte("benchmark content"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// bench/<date>-<rev>/<builder> is two directory levels deeper
+	// than anything that previously existed under bench/, which is
+	// exactly the case ensureDir's single-level os.Mkdir can't
+	// create.
+	err := linkBench("abcdefg1234", "linux-amd64", date, map[string]string{"benchmark": artifactPath})
+	if err != nil {
+		t.Fatalf("linkBench: %v", err)
+	}
+
+	link := path.Join("bench", "2024-01-02T03:04:05-abcdefg", "linux-amd64", "benchmark")
+	got, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("reading through symlink %s: %v", link, err)
+	}
+	if string(got) != "benchmark content" {
+		t.Errorf("reading through symlink %s = %q, want %q", link, got, "benchmark content")
+	}
+}
+
+func TestEnsureDirAllNested(t *testing.T) {
+	withTempOutDir(t)
+	ensureDirAll(path.Join("a", "b", "c"))
+	if _, err := os.Stat(path.Join("a", "b", "c")); err != nil {
+		t.Fatalf("ensureDirAll didn't create nested directories: %v", err)
+	}
+	// Calling it again on an existing directory shouldn't fail.
+	ensureDirAll(path.Join("a", "b", "c"))
+}
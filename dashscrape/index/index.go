@@ -0,0 +1,354 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package index maintains a searchable index over the build logs
+// fetched by dashscrape, so tools can query by builder, date range,
+// and log content instead of grepping the log/ directory directly.
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestFile is the name of the file, within an index's directory,
+// that records one Entry per indexed log.
+const manifestFile = "manifest.jsonl"
+
+// Entry describes a single indexed log.
+type Entry struct {
+	Revision string    `json:"revision"`
+	Builder  string    `json:"builder"`
+	Date     time.Time `json:"date"`
+	LogPath  string    `json:"logPath"`
+	// Tokens holds the distinct lowercase words of at least 3
+	// characters found in the log at LogPath, computed once when the entry is added. It backs
+	// the postings list Search uses to narrow a regexp query to
+	// the logs that could possibly match, instead of scanning
+	// every indexed log.
+	Tokens []string `json:"tokens"`
+}
+
+// Index is an inverted index over a set of build logs, keyed by
+// builder, date, and revision, with support for regexp queries over
+// log content. It's safe for concurrent use.
+type Index struct {
+	dir       string
+	mu        sync.Mutex
+	entries   []Entry
+	postings  map[string][]int // token -> indexes into entries
+	byLogPath map[string]bool  // LogPath of every indexed entry
+	f         *os.File         // manifestFile, open for appending
+}
+
+// Open opens or creates an index rooted at dir, creating dir and any
+// missing parents.
+func Open(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	x := &Index{dir: dir, postings: map[string][]int{}, byLogPath: map[string]bool{}}
+	path := filepath.Join(dir, manifestFile)
+	if data, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(data)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var e Entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				data.Close()
+				return nil, fmt.Errorf("parsing %s: %v", path, err)
+			}
+			x.index(e)
+		}
+		if err := scanner.Err(); err != nil {
+			data.Close()
+			return nil, err
+		}
+		data.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	x.f = f
+	return x, nil
+}
+
+// index appends e to x.entries and adds it to the postings list for
+// each of its tokens. The caller must hold x.mu, or be Open building
+// an index no one else can see yet.
+func (x *Index) index(e Entry) {
+	i := len(x.entries)
+	x.entries = append(x.entries, e)
+	x.byLogPath[e.LogPath] = true
+	for _, tok := range e.Tokens {
+		x.postings[tok] = append(x.postings[tok], i)
+	}
+}
+
+// Add records a newly fetched log in the index. It reads the log at
+// e.LogPath to tokenize its content for the postings list; callers
+// don't need to (and shouldn't) set e.Tokens themselves. It's a no-op
+// if e.LogPath is already indexed, so it's safe to call concurrently
+// and to call more than once for the same log.
+func (x *Index) Add(e Entry) error {
+	if x.has(e.LogPath) {
+		return nil
+	}
+
+	tokens, err := tokenizeFile(e.LogPath)
+	if err != nil {
+		return fmt.Errorf("tokenizing %s: %v", e.LogPath, err)
+	}
+	e.Tokens = tokens
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byLogPath[e.LogPath] {
+		// Lost a race with another Add for the same log.
+		return nil
+	}
+	if _, err := x.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	x.index(e)
+	return nil
+}
+
+// has reports whether LogPath is already indexed.
+func (x *Index) has(logPath string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.byLogPath[logPath]
+}
+
+// Query filters and searches the entries recorded in an Index.
+type Query struct {
+	// Builder, if non-empty, restricts the search to entries
+	// for this builder.
+	Builder string
+	// Since, if non-zero, restricts the search to entries with
+	// Date on or after Since.
+	Since time.Time
+	// Pattern, if non-nil, restricts the search to logs whose
+	// content matches this regexp.
+	Pattern *regexp.Regexp
+}
+
+// Match is a single result from Search.
+type Match struct {
+	Entry   Entry
+	Snippet string
+}
+
+// Search returns the entries matching q, each with a snippet of the
+// first matching line of log content (or the whole entry, if
+// q.Pattern is nil).
+//
+// If q.Pattern is a plain literal string, Search uses the postings
+// list built by Add to narrow the search to the logs that could
+// possibly contain a match before grepping any of them, so the cost
+// of a query scales with the number of logs that mention its terms
+// rather than with the total number of indexed logs.
+func (x *Index) Search(q Query) ([]Match, error) {
+	x.mu.Lock()
+	var entries []Entry
+	for _, i := range x.candidates(q.Pattern) {
+		entries = append(entries, x.entries[i])
+	}
+	x.mu.Unlock()
+
+	var matches []Match
+	for _, e := range entries {
+		if q.Builder != "" && e.Builder != q.Builder {
+			continue
+		}
+		if !q.Since.IsZero() && e.Date.Before(q.Since) {
+			continue
+		}
+		if q.Pattern == nil {
+			matches = append(matches, Match{Entry: e})
+			continue
+		}
+		snippet, err := grep(e.LogPath, q.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("searching %s: %v", e.LogPath, err)
+		}
+		if snippet == "" {
+			continue
+		}
+		matches = append(matches, Match{Entry: e, Snippet: snippet})
+	}
+	return matches, nil
+}
+
+// candidates returns the indexes into x.entries of the entries whose
+// logs could possibly match pattern, using the postings list. The
+// caller must hold x.mu.
+//
+// candidates only narrows the search when pattern is a plain literal
+// string (e.g. "panic: runtime error", with no regexp
+// metacharacters): in that case, every word in pattern with a
+// non-word character on both sides must appear as a whole word
+// somewhere in a matching log, so requiring all of them in the
+// postings list can only rule out logs that can't match. A word at
+// either end of pattern can match just part of a longer word in the
+// log ("untime" matches "runtime"), so it isn't looked up. Any
+// metacharacter (*, |, [...], and so on) can make that unsound --
+// "cat|dog" can match a log containing only "cat" -- so candidates
+// conservatively falls back to every entry whenever pattern isn't a
+// plain literal, or has no such words to look up.
+func (x *Index) candidates(pattern *regexp.Regexp) []int {
+	if pattern == nil || !isLiteral(pattern.String()) {
+		return x.allIndexes()
+	}
+
+	inner := regexp.MustCompile(`^\w+|\w+$`).ReplaceAllString(pattern.String(), "")
+	tokens := tokenize(inner)
+	if len(tokens) == 0 {
+		return x.allIndexes()
+	}
+
+	// An entry is a candidate only if its log contains every word
+	// looked up from the literal pattern.
+	hits := map[int]int{}
+	for _, tok := range tokens {
+		for _, i := range x.postings[tok] {
+			hits[i]++
+		}
+	}
+	var idxs []int
+	for i, n := range hits {
+		if n == len(tokens) {
+			idxs = append(idxs, i)
+		}
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+// allIndexes returns every index into x.entries, in order.
+func (x *Index) allIndexes() []int {
+	all := make([]int, len(x.entries))
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// regexpMeta is the set of ASCII characters with special meaning in
+// Go's regexp syntax.
+const regexpMeta = `.^$*+?()[]{}|\`
+
+// isLiteral reports whether s contains no regexp metacharacters, so
+// regexp.MustCompile(s) can only ever match the literal substring s
+// itself.
+func isLiteral(s string) bool {
+	return !strings.ContainsAny(s, regexpMeta)
+}
+
+// grep returns the first line of the file at path that matches re,
+// or "" if there is no match.
+func grep(path string, re *regexp.Regexp) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if re.MatchString(line) {
+			return strings.TrimSpace(line), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// tokenWord matches a run of word characters, the unit tokenize and
+// the postings list key on.
+var tokenWord = regexp.MustCompile(`\w+`)
+
+// tokenize splits s into its distinct lowercase words of at least 3
+// characters. Shorter words are dropped: they're too common to narrow
+// a search usefully and would bloat the postings list.
+func tokenize(s string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, w := range tokenWord.FindAllString(strings.ToLower(s), -1) {
+		if len(w) < 3 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+// tokenizeFile returns the distinct lowercase words of at least 3
+// characters found in the file at path.
+func tokenizeFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return tokenize(string(data)), nil
+}
+
+// ParseQuery parses a search query of the form
+//
+//	[builder:<name>] [since:<YYYY-MM-DD>] <regexp>
+//
+// where <regexp> may optionally be wrapped in slashes (e.g.
+// /panic: runtime/), into a Query.
+func ParseQuery(s string) (Query, error) {
+	var q Query
+	var patternFields []string
+	for _, field := range strings.Fields(s) {
+		switch {
+		case strings.HasPrefix(field, "builder:"):
+			q.Builder = strings.TrimPrefix(field, "builder:")
+		case strings.HasPrefix(field, "since:"):
+			t, err := time.Parse("2006-01-02", strings.TrimPrefix(field, "since:"))
+			if err != nil {
+				return Query{}, fmt.Errorf("parsing since: %v", err)
+			}
+			q.Since = t
+		default:
+			patternFields = append(patternFields, field)
+		}
+	}
+
+	pattern := strings.TrimSpace(strings.Join(patternFields, " "))
+	pattern = strings.TrimPrefix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Query{}, fmt.Errorf("parsing pattern: %v", err)
+		}
+		q.Pattern = re
+	}
+	return q, nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query       string
+		wantBuilder string
+		wantSince   string // "" if zero
+		wantPattern string // "" if nil
+	}{
+		{"", "", "", ""},
+		{"builder:linux-amd64", "linux-amd64", "", ""},
+		{"since:2024-01-01", "", "2024-01-01", ""},
+		{"panic: runtime", "", "", "panic: runtime"},
+		{"/panic: runtime/", "", "", "panic: runtime"},
+		{
+			"builder:linux-amd64 since:2024-01-01 /panic: runtime/",
+			"linux-amd64", "2024-01-01", "panic: runtime",
+		},
+		// Field order shouldn't matter.
+		{
+			"/panic/ since:2024-01-01 builder:linux-amd64",
+			"linux-amd64", "2024-01-01", "panic",
+		},
+	}
+	for _, tc := range tests {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.query, err)
+			continue
+		}
+		if q.Builder != tc.wantBuilder {
+			t.Errorf("ParseQuery(%q).Builder = %q, want %q", tc.query, q.Builder, tc.wantBuilder)
+		}
+		var gotSince string
+		if !q.Since.IsZero() {
+			gotSince = q.Since.Format("2006-01-02")
+		}
+		if gotSince != tc.wantSince {
+			t.Errorf("ParseQuery(%q).Since = %q, want %q", tc.query, gotSince, tc.wantSince)
+		}
+		var gotPattern string
+		if q.Pattern != nil {
+			gotPattern = q.Pattern.String()
+		}
+		if gotPattern != tc.wantPattern {
+			t.Errorf("ParseQuery(%q).Pattern = %q, want %q", tc.query, gotPattern, tc.wantPattern)
+		}
+	}
+}
+
+func TestParseQueryBadSince(t *testing.T) {
+	if _, err := ParseQuery("since:not-a-date"); err == nil {
+		t.Error("ParseQuery with an invalid since: date succeeded, want error")
+	}
+}
+
+func TestParseQueryBadPattern(t *testing.T) {
+	if _, err := ParseQuery("/[/"); err == nil {
+		t.Error("ParseQuery with an invalid pattern succeeded, want error")
+	}
+}
+
+// newTestIndex creates an Index in a temporary directory and adds one
+// entry per log in logs, keyed by the map's key (used as the log's
+// file name and its Builder).
+func newTestIndex(t *testing.T, logs map[string]string, dates map[string]time.Time) *Index {
+	t.Helper()
+	dir := t.TempDir()
+
+	idx, err := Open(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for builder, content := range logs {
+		logPath := filepath.Join(dir, builder+".log")
+		if err := ioutil.WriteFile(logPath, []byte(content), 0666); err != nil {
+			t.Fatalf("writing %s: %v", logPath, err)
+		}
+		if err := idx.Add(Entry{
+			Revision: "rev-" + builder,
+			Builder:  builder,
+			Date:     dates[builder],
+			LogPath:  logPath,
+		}); err != nil {
+			t.Fatalf("Add(%s): %v", builder, err)
+		}
+	}
+	return idx
+}
+
+func TestSearchFilters(t *testing.T) {
+	jan1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jan15 := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	idx := newTestIndex(t, map[string]string{
+		"linux-amd64": "ok\npanic: runtime error\n",
+		"darwin-arm64": "ok\nall tests passed\n",
+	}, map[string]time.Time{
+		"linux-amd64":  jan1,
+		"darwin-arm64": jan15,
+	})
+
+	t.Run("no filter", func(t *testing.T) {
+		matches, err := idx.Search(Query{})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 2 {
+			t.Fatalf("got %d matches, want 2", len(matches))
+		}
+	})
+
+	t.Run("builder", func(t *testing.T) {
+		matches, err := idx.Search(Query{Builder: "linux-amd64"})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 1 || matches[0].Entry.Builder != "linux-amd64" {
+			t.Fatalf("got %v, want one match for linux-amd64", matches)
+		}
+	})
+
+	t.Run("since", func(t *testing.T) {
+		matches, err := idx.Search(Query{Since: jan15})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 1 || matches[0].Entry.Builder != "darwin-arm64" {
+			t.Fatalf("got %v, want one match for darwin-arm64", matches)
+		}
+	})
+
+	t.Run("pattern", func(t *testing.T) {
+		q, err := ParseQuery("/panic: runtime/")
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		matches, err := idx.Search(q)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 1 || matches[0].Entry.Builder != "linux-amd64" {
+			t.Fatalf("got %v, want one match for linux-amd64", matches)
+		}
+		if matches[0].Snippet != "panic: runtime error" {
+			t.Errorf("Snippet = %q, want %q", matches[0].Snippet, "panic: runtime error")
+		}
+	})
+
+	t.Run("pattern with no hits", func(t *testing.T) {
+		q, err := ParseQuery("/segfault/")
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		matches, err := idx.Search(q)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 0 {
+			t.Fatalf("got %v, want no matches", matches)
+		}
+	})
+
+	t.Run("combined filters", func(t *testing.T) {
+		q, err := ParseQuery("builder:darwin-arm64 since:2024-01-01 /passed/")
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		matches, err := idx.Search(q)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(matches) != 1 || matches[0].Entry.Builder != "darwin-arm64" {
+			t.Fatalf("got %v, want one match for darwin-arm64", matches)
+		}
+	})
+}
+
+// TestSearchCandidatesFallBack checks that a pattern with regexp
+// metacharacters still finds matches by falling back to a full scan,
+// rather than deriving a postings lookup from its non-literal text
+// that could wrongly exclude a match.
+func TestSearchCandidatesFallBack(t *testing.T) {
+	idx := newTestIndex(t, map[string]string{
+		"linux-amd64": "line one\nline two\n",
+	}, nil)
+
+	q, err := ParseQuery(`/^line/`)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	matches, err := idx.Search(q)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(matches))
+	}
+}
+
+// TestSearchAlternationNotExcluded guards against the postings
+// pre-filter requiring every word of an alternation pattern like
+// "cat|dog" to be present, which would wrongly exclude a log that
+// only contains "cat".
+func TestSearchAlternationNotExcluded(t *testing.T) {
+	idx := newTestIndex(t, map[string]string{
+		"linux-amd64": "the cat sat\n",
+	}, nil)
+
+	q, err := ParseQuery(`/cat|dog/`)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	matches, err := idx.Search(q)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1 (alternation should not require every branch's words)", len(matches))
+	}
+}
+
+func TestAddPersistsAcrossOpen(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "build.log")
+	if err := ioutil.WriteFile(logPath, []byte("panic: oops\n"), 0666); err != nil {
+		t.Fatalf("writing log: %v", err)
+	}
+
+	idxDir := filepath.Join(dir, "index")
+	idx, err := Open(idxDir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := idx.Add(Entry{Revision: "abc", Builder: "linux-amd64", LogPath: logPath}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// Reopen and make sure the entry survives.
+	idx2, err := Open(idxDir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	q, err := ParseQuery("/panic/")
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	matches, err := idx2.Search(q)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Entry.Revision != "abc" {
+		t.Fatalf("got %v, want one match for revision abc", matches)
+	}
+}
+
+// TestAddIdempotent checks that calling Add more than once for the
+// same LogPath doesn't duplicate the entry, as callers like
+// dashscrape's fetchLog rely on: they index a log whether it was
+// just downloaded or already present from a previous run, without
+// tracking which case they're in themselves.
+func TestAddIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "build.log")
+	if err := ioutil.WriteFile(logPath, []byte("panic: oops\n"), 0666); err != nil {
+		t.Fatalf("writing log: %v", err)
+	}
+
+	idx, err := Open(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	e := Entry{Revision: "abc", Builder: "linux-amd64", LogPath: logPath}
+	for i := 0; i < 3; i++ {
+		if err := idx.Add(e); err != nil {
+			t.Fatalf("Add #%d: %v", i, err)
+		}
+	}
+
+	matches, err := idx.Search(Query{})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d entries after 3 Adds of the same log, want 1", len(matches))
+	}
+}
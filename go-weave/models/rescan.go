@@ -7,10 +7,30 @@
 // rescan is a model of two concurrent stack re-scanning approaches:
 // transitive mark write barriers, and scan restarting.
 //
-// This model is somewhat limited. The mutator is uninteresting and it
-// doesn't model concurrent write barriers (or the mark quiescence
-// necessary with concurrent write barriers). This model formed the
-// basis for the yuasa model, which is much more complete.
+// Marking is itself concurrent and interleavable: the root and each
+// stack re-scan push work onto mark workers' gray queues rather than
+// marking inline, so two workers can race on the same object, and
+// the write barrier enqueues the pointers it publishes rather than
+// marking them inline. Mark termination (waitMarkDone) only lets the
+// final world.Lock() proceed once no gray work remains anywhere
+// (worker queues or in-flight write barriers), rechecking after the
+// world is stopped in case new work snuck in first.
+//
+// The mutator also allocates, exercising the question of what color
+// a newly allocated object should be (blackAlloc), and a concurrent
+// sweeper reclaims everything left unmarked once mark termination
+// completes. checkmark verifies the sweeper never reclaims a
+// reachable object. Of the combinations of {writeMarks, writeRestarts,
+// blackAlloc} the amb scheduler explores, the model refutes only
+// writeMarks=false, writeRestarts=false, blackAlloc=false: with
+// neither a marking barrier, a restart, nor black allocation, a
+// pointer to a white object can be published into an already-scanned
+// part of the heap and then swept out from under it. Every other
+// combination is safe, since at least one of the three mechanisms
+// ends up keeping the object alive.
+//
+// This model formed the basis for the yuasa model, which is much
+// more complete.
 package main
 
 import (
@@ -28,6 +48,13 @@ const writeMarks = true
 // stack scan.
 const writeRestarts = false
 
+// blackAlloc indicates that objects are allocated already marked
+// ("black"), so the sweeper never needs to consider them regardless
+// of whether they've been scanned. If false, new objects start
+// unmarked ("white") like everything else, and survive only if
+// writeMarks or writeRestarts keeps them alive.
+const blackAlloc = true
+
 // ptr is a memory pointer, as an index into mem. 0 is the nil
 // pointer.
 type ptr int
@@ -48,12 +75,43 @@ var marked []bool
 
 const numThreads = 2
 
+// numMarkers is the number of concurrent mark workers draining the
+// gray queues.
+const numMarkers = 2
+
 const stackBase ptr = 1
 const globalRoot ptr = stackBase + ptr(numThreads)
 
 var scanClock int
 var world weave.RWMutex
 
+// grayQueues[i] is mark worker i's work queue: objects that have
+// been greyed (marked, but not yet scanned for outgoing edges). The
+// root mark, the stack re-scans, and the write barrier all push onto
+// an ambiguously chosen worker's queue, so any of them can hand work
+// to any worker.
+var grayQueues [numMarkers][]ptr
+
+// grayCount is the number of outstanding units of gray work:
+// entries sitting in some grayQueues slot, plus an object a worker
+// has popped but not yet finished scanning. Mark termination must
+// not stop the world until this is zero.
+var grayCount int
+
+// markDone is set once mark termination has confirmed grayCount is
+// zero with the world stopped, so mark workers can stop spinning and
+// return.
+var markDone bool
+
+// freed records which objects the sweeper has reclaimed this cycle.
+// checkmark uses it to verify the sweeper never reclaims an object
+// that's still reachable.
+var freed []bool
+
+// sweepDone is set once the sweeper has finished walking the heap,
+// so the coordinator knows it's safe to resume the world.
+var sweepDone bool
+
 const verbose = false
 
 var sched = weave.Scheduler{Strategy: &amb.StrategyRandom{}}
@@ -75,14 +133,25 @@ func main() {
 			}
 		}
 		marked = make([]bool, len(mem))
+		freed = make([]bool, len(mem))
 		if verbose {
 			printMem(mem, marked)
 		}
 		scanClock = 0
 		world = weave.RWMutex{} // Belt and suspenders.
+		grayQueues = [numMarkers][]ptr{}
+		grayCount = 0
+		markDone = false
+		sweepDone = false
+
+		// Start the mark workers.
+		for i := 0; i < numMarkers; i++ {
+			i := i
+			sched.Go(func() { markWorker(i) })
+		}
 
 		// Mark the global root.
-		mark(globalRoot, marked, "globalRoot")
+		greyObj(ambWorker(), globalRoot)
 
 		// Start mutators.
 		for i := 0; i < numThreads; i++ {
@@ -96,12 +165,24 @@ func main() {
 				println("scan", scanClock)
 			}
 			scanClock++
-			mark(mem[stackBase+ptr(scanClock-1)].l, marked, "scan")
+			greyObj(ambWorker(), mem[stackBase+ptr(scanClock-1)].l)
 		}
 
-		// Wait for write barriers to complete.
-		world.Lock()
-		defer world.Unlock()
+		// Mark termination: wait for all gray work to drain,
+		// then stop the world and make sure it's still
+		// drained (the world stop and the drain check race
+		// with the write barrier, so we may have to retry).
+		waitMarkDone()
+		markDone = true
+
+		// Hand off to the concurrent sweeper while the world
+		// is still stopped, then resume the world once it's
+		// done.
+		sched.Go(sweeper)
+		for !sweepDone {
+			sched.Sched()
+		}
+		world.Unlock()
 
 		// Check that everything is marked.
 		if verbose {
@@ -127,7 +208,7 @@ func ambHeapPointer() ptr {
 // pointer. Note that the object may not be marked.
 func ambReachableHeapPointer() ptr {
 	reachable := make([]bool, len(mem))
-	mark(globalRoot, reachable, "")
+	reachableFrom(globalRoot, reachable)
 
 	nreachable := 0
 	for _, m := range reachable[globalRoot:] {
@@ -147,24 +228,151 @@ func ambReachableHeapPointer() ptr {
 	panic("not reached")
 }
 
+// reachableFrom computes the ghost set of objects reachable from p,
+// purely so ambReachableHeapPointer can pick a valid mutator target.
+// Unlike greyObj/markWorker below, this has nothing to do with the
+// real concurrent mark: it never yields to the scheduler, so it's
+// atomic with respect to every other goroutine.
+func reachableFrom(p ptr, reachable []bool) {
+	if p == 0 || reachable[p] {
+		return
+	}
+	reachable[p] = true
+	reachableFrom(mem[p].l, reachable)
+	reachableFrom(mem[p].r, reachable)
+}
+
+// ambWorker ambiguously picks which mark worker's gray queue a new
+// unit of work should land on, modeling the fact that a mark can
+// arrive from the root, a stack re-scan, or any write barrier and be
+// picked up by any worker.
+func ambWorker() int {
+	return sched.Amb(numMarkers)
+}
+
+// greyObj marks p and pushes it onto worker's gray queue, if it
+// isn't already marked. It's used by the root mark, the stack
+// re-scans, and the write barrier, so all of them feed the same
+// pool of concurrent mark workers.
+func greyObj(worker int, p ptr) {
+	if p == 0 || marked[p] {
+		return
+	}
+	marked[p] = true
+	grayQueues[worker] = append(grayQueues[worker], p)
+	grayCount++
+}
+
+// markWorker drains worker's gray queue, scanning one field of one
+// object at a time and yielding to the scheduler between each field
+// so that this worker, other mark workers, the write barrier, and
+// the mutators can all interleave arbitrarily.
+func markWorker(worker int) {
+	for {
+		q := grayQueues[worker]
+		if len(q) == 0 {
+			if markDone {
+				return
+			}
+			// Nothing to do right now, but another
+			// worker or the write barrier may still hand
+			// us work.
+			sched.Sched()
+			continue
+		}
+
+		p := q[0]
+		grayQueues[worker] = q[1:]
+
+		greyObj(ambWorker(), mem[p].l)
+		sched.Sched()
+		greyObj(ambWorker(), mem[p].r)
+		sched.Sched()
+
+		grayCount--
+	}
+}
+
+// waitMarkDone implements mark termination: it waits until no gray
+// work remains, then stops the world and re-checks, since a write
+// barrier can race the world stop and enqueue new work in the gap.
+// If that happens, it resumes the world and tries again. This leaves
+// the world locked on return.
+func waitMarkDone() {
+	for {
+		for grayCount != 0 {
+			sched.Sched()
+		}
+		world.Lock()
+		if grayCount == 0 {
+			return
+		}
+		world.Unlock()
+	}
+}
+
+// alloc allocates a fresh, pointer-free object, growing mem under
+// world.RLock() so it can't race the concurrent sweeper walking mem,
+// and returns a pointer to it. The object is born marked iff
+// blackAlloc.
+func alloc() ptr {
+	world.RLock()
+	defer world.RUnlock()
+
+	p := ptr(len(mem))
+	mem = append(mem, obj{})
+	marked = append(marked, blackAlloc)
+	freed = append(freed, false)
+	return p
+}
+
+// sweeper reclaims every object that didn't get marked this cycle.
+// It runs as its own goroutine, started once mark termination has
+// stopped the world, so the scheduler can explore it interleaved
+// with whatever else is still runnable (such as a mutator's
+// unsynchronized heap read) during the mark/sweep handoff.
+func sweeper() {
+	for p := globalRoot; int(p) < len(mem); p++ {
+		if !marked[p] {
+			freed[p] = true
+		}
+	}
+	sweepDone = true
+}
+
+// wbarrierPtr is like wbarrier, but publishes newPtr into slot
+// directly instead of indirecting through another memory slot's l
+// field. It's used to publish a freshly allocated object into the
+// heap.
+func wbarrierPtr(slot, newPtr ptr) {
+	if newPtr != 0 {
+		if writeMarks {
+			world.RLock()
+			greyObj(ambWorker(), newPtr)
+			world.RUnlock()
+		}
+		if writeRestarts {
+			if !marked[newPtr] {
+				scanClock = 0
+			}
+		}
+	}
+	mem[slot].l = newPtr
+	sched.Sched()
+}
+
 func wbarrier(slot, val ptr) {
 	// TODO: Check that GC is still running?
 
-	// TODO: Need to mark val regardless (but doesn't have to be
-	// transitive).
-
 	if val != 0 {
 		if writeMarks {
-			func() {
-				// Block STW termination while marking.
-				world.RLock()
-				defer world.RUnlock()
-				// TODO: In reality, concurrent marks
-				// can collide with each other, so we
-				// need mark quiescence. This doesn't
-				// model that.
-				mark(mem[val].l, marked, "barrier")
-			}()
+			// Block mark termination while we enqueue
+			// the mark: once grayCount reflects it,
+			// waitMarkDone will wait for some worker to
+			// actually drain it before declaring victory.
+			world.RLock()
+			greyObj(ambWorker(), mem[val].l)
+			world.RUnlock()
 		}
 		if writeRestarts {
 			if !marked[val] {
@@ -197,26 +405,14 @@ func mutator(id int) {
 	obj = ambReachableHeapPointer()
 	mem[sptr].l = mem[obj].l
 	sched.Sched()
-}
 
-func mark(p ptr, marked []bool, name string) {
-	if p == 0 || marked[p] {
-		return
-	}
-	marked[p] = true
-	if name != "" {
-		if verbose {
-			println(name, "marked", p)
-		}
-	}
-	mark(mem[p].l, marked, name)
-	if name != "" {
-		sched.Sched()
-	}
-	mark(mem[p].r, marked, name)
-	if name != "" {
-		sched.Sched()
-	}
+	// Allocate a fresh object and publish it into the heap. This
+	// exercises the black/white allocation question: if the
+	// object isn't born marked, does it still survive the
+	// concurrent sweep that follows this mark cycle?
+	p := alloc()
+	obj = ambReachableHeapPointer()
+	wbarrierPtr(obj, p)
 }
 
 func checkmark(p ptr) {
@@ -229,6 +425,9 @@ func checkmark(p ptr) {
 		if !marked[p] {
 			panic(fmt.Sprintf("object not marked: %d", p))
 		}
+		if freed[p] {
+			panic(fmt.Sprintf("reachable object was swept: %d", p))
+		}
 		if checkmarked[p] {
 			return
 		}